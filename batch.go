@@ -0,0 +1,217 @@
+package gkvlite
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"unsafe"
+)
+
+// BatchReplay receives the mutations recorded in a Batch, in the
+// order they were recorded (not the sorted, deduped order in which
+// ApplyBatch() applies them).
+type BatchReplay interface {
+	OnSet(key, val []byte, priority int32)
+	OnDelete(key []byte)
+}
+
+type batchOpKind int
+
+const (
+	batchOpSet batchOpKind = iota
+	batchOpDelete
+)
+
+type batchOp struct {
+	kind batchOpKind
+	item *Item
+}
+
+// A Batch records a sequence of Set/Delete mutations against a
+// Collection so that they may later be applied atomically via
+// Collection.ApplyBatch(), instead of paying a separate
+// rootAddRef/union-or-split/rootCAS/rootDecRef cycle per mutation.
+type Batch struct {
+	coll *Collection
+	ops  []batchOp
+}
+
+// NewBatch creates an empty Batch for mutating this Collection.
+func (t *Collection) NewBatch() *Batch {
+	return &Batch{coll: t}
+}
+
+// Set records a Set mutation of key/val into the batch.
+// A random item Priority (e.g., rand.Int31()) will usually work well.
+func (b *Batch) Set(key, val []byte) error {
+	return b.SetItem(&Item{Key: key, Val: val, Priority: rand.Int31()})
+}
+
+// SetItem records a Set mutation of an Item into the batch.  The
+// input Item instance should be considered immutable and owned by
+// the Batch until the batch is applied or reset.
+func (b *Batch) SetItem(item *Item) error {
+	if item.Key == nil || len(item.Key) > 0xffff || len(item.Key) == 0 ||
+		item.Val == nil {
+		return errors.New("Item.Key/Val missing or too long")
+	}
+	if item.Priority < 0 {
+		return errors.New("Item.Priority must be non-negative")
+	}
+	b.ops = append(b.ops, batchOp{kind: batchOpSet, item: item})
+	return nil
+}
+
+// Delete records a Delete mutation of key into the batch.
+func (b *Batch) Delete(key []byte) error {
+	if key == nil || len(key) == 0 {
+		return errors.New("Item.Key missing")
+	}
+	b.ops = append(b.ops, batchOp{kind: batchOpDelete, item: &Item{Key: key}})
+	return nil
+}
+
+// Len returns the number of mutations currently recorded in the batch.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Reset empties the batch so that it may be reused.
+func (b *Batch) Reset() {
+	b.ops = b.ops[:0]
+}
+
+// Replay invokes the given BatchReplay for every mutation recorded
+// in the batch, in the order the mutations were recorded.
+func (b *Batch) Replay(r BatchReplay) {
+	for _, op := range b.ops {
+		switch op.kind {
+		case batchOpSet:
+			r.OnSet(op.item.Key, op.item.Val, op.item.Priority)
+		case batchOpDelete:
+			r.OnDelete(op.item.Key)
+		}
+	}
+}
+
+// ApplyBatch performs all the mutations recorded in the batch against
+// a single snapshotted root, sorted and deduped by the collection's
+// KeyCompare (last write for a repeated key wins), and installs the
+// result with one rootCAS() -- giving batched mutations all-or-nothing
+// visibility, unlike SetItem/Delete in a loop.
+func (t *Collection) ApplyBatch(b *Batch) error {
+	if b.coll != t {
+		return errors.New("batch belongs to a different Collection")
+	}
+	if len(b.ops) == 0 {
+		return nil
+	}
+
+	ops := make([]batchOp, len(b.ops))
+	copy(ops, b.ops)
+	sort.SliceStable(ops, func(i, j int) bool {
+		return t.compare(ops[i].item.Key, ops[j].item.Key) < 0
+	})
+	deduped := ops[:0]
+	for i, op := range ops {
+		if i > 0 && t.compare(op.item.Key, deduped[len(deduped)-1].item.Key) == 0 {
+			deduped[len(deduped)-1] = op // Last write for a given key wins.
+			continue
+		}
+		deduped = append(deduped, op)
+	}
+
+	rnl := t.rootAddRef()
+	defer t.rootDecRef(rnl)
+	cur := rnl.root
+
+	// Read from cur, the exact root this batch's rootCAS is about to
+	// replace, so a concurrently-landed mutation can't hand back a
+	// stale prev.
+	var prevs map[string]*Item
+	if len(t.indexes) > 0 {
+		prevs = make(map[string]*Item, len(deduped))
+		for _, op := range deduped {
+			prev, err := t.getItemFromRoot(cur, op.item.Key, true, true)
+			if err != nil {
+				return err
+			}
+			prevs[string(op.item.Key)] = prev
+		}
+	}
+
+	var reclaim []*node
+	var nlocsToFree []*nodeLoc
+
+	for _, op := range deduped {
+		switch op.kind {
+		case batchOpSet:
+			n := t.mkNode(nil, nil, nil, 1,
+				uint64(len(op.item.Key))+uint64(op.item.NumValBytes(t)))
+			n.item.item = unsafe.Pointer(op.item)
+			nloc := t.mkNodeLoc(n)
+			r, err := t.store.union(t, cur, nloc)
+			if err != nil {
+				return err
+			}
+			cur = r
+			reclaim = append(reclaim, n) // Can't reclaim n right now because r might point to n.
+			nlocsToFree = append(nlocsToFree, nloc)
+		case batchOpDelete:
+			left, middle, right, err := t.store.split(t, cur, op.item.Key)
+			if err != nil {
+				return err
+			}
+			if !middle.isEmpty() {
+				t.markReclaimable(middle.Node())
+				t.freeNodeLoc(middle)
+			}
+			cur, err = t.store.join(t, left, right)
+			if err != nil {
+				return err
+			}
+			if !left.isEmpty() { // Can't reclaim left right now due to readers.
+				reclaim = append(reclaim, left.Node())
+				nlocsToFree = append(nlocsToFree, left)
+			}
+			if !right.isEmpty() { // Can't reclaim right right now due to readers.
+				reclaim = append(reclaim, right.Node())
+				nlocsToFree = append(nlocsToFree, right)
+			}
+		}
+	}
+
+	rnlNew := t.mkRootNodeLoc(cur)
+	rnlNew.reclaimLater = reclaim // Can't reclaim these yet; cur may still point to any of them.
+	if !t.rootCAS(rnl, rnlNew) {
+		return errors.New("concurrent mutation attempted")
+	}
+	t.rootDecRef(rnl)
+	for _, nloc := range nlocsToFree {
+		t.freeNodeLoc(nloc)
+	}
+
+	// The batch already committed above; index sync is best-effort
+	// from here, so every op still runs and failures are reported
+	// together via IndexSyncError rather than stopping early.
+	var syncErrs []error
+	for _, op := range deduped {
+		prev := prevs[string(op.item.Key)]
+		for _, idx := range t.indexes {
+			var err error
+			switch op.kind {
+			case batchOpSet:
+				err = idx.onSet(prev, op.item)
+			case batchOpDelete:
+				err = idx.onDelete(prev)
+			}
+			if err != nil {
+				syncErrs = append(syncErrs, err)
+			}
+		}
+	}
+	if len(syncErrs) > 0 {
+		return &IndexSyncError{Errs: syncErrs}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package gkvlite
+
+import (
+	"bytes"
+	"testing"
+)
+
+type batchReplayRecorder struct {
+	sets    [][2]string
+	deletes []string
+}
+
+func (r *batchReplayRecorder) OnSet(key, val []byte, priority int32) {
+	r.sets = append(r.sets, [2]string{string(key), string(val)})
+}
+
+func (r *batchReplayRecorder) OnDelete(key []byte) {
+	r.deletes = append(r.deletes, string(key))
+}
+
+func TestBatchLenReset(t *testing.T) {
+	c := newTestCollection(t)
+	b := c.NewBatch()
+	if b.Len() != 0 {
+		t.Fatalf("Len() = %d, want 0", b.Len())
+	}
+	if err := b.Set([]byte("a"), []byte("1")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := b.Delete([]byte("b")); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if b.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", b.Len())
+	}
+	b.Reset()
+	if b.Len() != 0 {
+		t.Fatalf("Len() after Reset() = %d, want 0", b.Len())
+	}
+}
+
+func TestBatchReplay(t *testing.T) {
+	c := newTestCollection(t)
+	b := c.NewBatch()
+	if err := b.Set([]byte("a"), []byte("1")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := b.Delete([]byte("b")); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	var r batchReplayRecorder
+	b.Replay(&r)
+	if len(r.sets) != 1 || r.sets[0] != [2]string{"a", "1"} {
+		t.Fatalf("Replay() sets = %v, want [[a 1]]", r.sets)
+	}
+	if len(r.deletes) != 1 || r.deletes[0] != "b" {
+		t.Fatalf("Replay() deletes = %v, want [b]", r.deletes)
+	}
+}
+
+// TestApplyBatchDedupeLastWriteWins regression-tests that repeated
+// mutations of the same key within a batch collapse to the last one
+// recorded, not the first.
+func TestApplyBatchDedupeLastWriteWins(t *testing.T) {
+	c := newTestCollection(t)
+	b := c.NewBatch()
+	if err := b.Set([]byte("a"), []byte("first")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := b.Set([]byte("a"), []byte("second")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := c.ApplyBatch(b); err != nil {
+		t.Fatalf("ApplyBatch: %v", err)
+	}
+	val, err := c.Get([]byte("a"))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !bytes.Equal(val, []byte("second")) {
+		t.Fatalf("Get(a) = %q, want %q", val, "second")
+	}
+}
+
+// TestApplyBatchAtomicVisibility regression-tests that a batch's
+// mutations all become visible together: a reader pinned on a
+// Snapshot taken before ApplyBatch() must see none of the batch's
+// writes, never a partial subset.
+func TestApplyBatchAtomicVisibility(t *testing.T) {
+	c := newTestCollection(t)
+	if err := c.Set([]byte("z"), []byte("pre")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	snap := c.Snapshot()
+	defer snap.Close()
+
+	b := c.NewBatch()
+	for _, k := range []string{"a", "b", "c"} {
+		if err := b.Set([]byte(k), []byte(k)); err != nil {
+			t.Fatalf("Set(%s): %v", k, err)
+		}
+	}
+	if err := b.Delete([]byte("z")); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := c.ApplyBatch(b); err != nil {
+		t.Fatalf("ApplyBatch: %v", err)
+	}
+
+	for _, k := range []string{"a", "b", "c"} {
+		i, err := snap.GetItem([]byte(k), false)
+		if err != nil {
+			t.Fatalf("snap.GetItem(%s): %v", k, err)
+		}
+		if i != nil {
+			t.Fatalf("snap.GetItem(%s) = %v, want nil (batch applied after snapshot taken)", k, i)
+		}
+	}
+	i, err := snap.GetItem([]byte("z"), false)
+	if err != nil {
+		t.Fatalf("snap.GetItem(z): %v", err)
+	}
+	if i == nil {
+		t.Fatalf("snap.GetItem(z) = nil, want pre-batch item still visible via snapshot")
+	}
+
+	for _, k := range []string{"a", "b", "c"} {
+		val, err := c.Get([]byte(k))
+		if err != nil {
+			t.Fatalf("Get(%s): %v", k, err)
+		}
+		if !bytes.Equal(val, []byte(k)) {
+			t.Fatalf("Get(%s) = %q, want %q", k, val, k)
+		}
+	}
+	val, err := c.Get([]byte("z"))
+	if err != nil {
+		t.Fatalf("Get(z): %v", err)
+	}
+	if val != nil {
+		t.Fatalf("Get(z) = %q, want nil (deleted by batch)", val)
+	}
+}
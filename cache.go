@@ -0,0 +1,166 @@
+package gkvlite
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// CacheStats reports LRU item-cache activity for a Store, mirroring
+// the counters goleveldb's cache package exposes.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Bytes     uint64 // Current resident item bytes.
+}
+
+// itemCacheKey identifies a resident item by the Collection it
+// belongs to and the tree node holding it, the same (collection,
+// node pointer) addressing EvictSomeItems() already walks today.
+type itemCacheKey struct {
+	coll *Collection
+	n    *node
+}
+
+type itemCacheEntry struct {
+	key   itemCacheKey
+	bytes uint64
+}
+
+// itemCache is a byte-budgeted LRU cache of resident *Item values,
+// configured per-Store via StoreCallbacks.ItemCacheBytes. Replaces
+// EvictSomeItems()'s random walk with eviction of the coldest items
+// once the byte budget is exceeded; a budget of 0 disables it.
+type itemCache struct {
+	mu       sync.Mutex
+	maxBytes uint64
+	curBytes uint64
+	ll       *list.List // Front = most-recently-used.
+	elems    map[itemCacheKey]*list.Element
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+// newItemCache creates an LRU item cache bounded to maxBytes of
+// resident item bytes.
+func newItemCache(maxBytes uint64) *itemCache {
+	return &itemCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		elems:    make(map[itemCacheKey]*list.Element),
+	}
+}
+
+// touch records a cache hit or miss for (coll, n) and, on a hit,
+// moves the entry to the front of the LRU.  Returns true on a miss,
+// so a caller that loads the item's value off disk can admit() it.
+func (c *itemCache) touch(coll *Collection, n *node) (wasMiss bool) {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.elems[itemCacheKey{coll, n}]; ok {
+		c.ll.MoveToFront(e)
+		c.hits++
+		return false
+	}
+	c.misses++
+	return true
+}
+
+// admit records that an item is now resident for (coll, n),
+// occupying itemBytes, evicting the coldest evictable entries as
+// needed to stay within the byte budget.
+func (c *itemCache) admit(coll *Collection, n *node, itemBytes uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := itemCacheKey{coll, n}
+	if e, ok := c.elems[key]; ok {
+		entry := e.Value.(*itemCacheEntry)
+		c.curBytes += itemBytes - entry.bytes
+		entry.bytes = itemBytes
+		c.ll.MoveToFront(e)
+	} else {
+		e := c.ll.PushFront(&itemCacheEntry{key: key, bytes: itemBytes})
+		c.elems[key] = e
+		c.curBytes += itemBytes
+	}
+	for c.curBytes > c.maxBytes {
+		e := c.evictableBackLocked()
+		if e == nil {
+			break // Every resident entry is still unflushed; nothing left we can evict.
+		}
+		c.evictElemLocked(e)
+	}
+}
+
+// evictableBackLocked returns the coldest entry that is safe to
+// evict -- i.e. already written to disk -- or nil if none qualifies.
+func (c *itemCache) evictableBackLocked() *list.Element {
+	for e := c.ll.Back(); e != nil; e = e.Prev() {
+		entry := e.Value.(*itemCacheEntry)
+		if !entry.key.n.item.Loc().isEmpty() {
+			return e
+		}
+	}
+	return nil
+}
+
+func (c *itemCache) evictElemLocked(e *list.Element) {
+	entry := e.Value.(*itemCacheEntry)
+	c.ll.Remove(e)
+	delete(c.elems, entry.key)
+	c.curBytes -= entry.bytes
+	c.evictions++
+	atomic.StorePointer(&entry.key.n.item.item, unsafe.Pointer(nil))
+}
+
+// Purge drops every entry from the cache, clearing each node's
+// resident *Item pointer.  Collection.EvictSomeItems() is a thin
+// wrapper around this when a Store has an LRU cache configured.
+func (c *itemCache) Purge() (numEvicted uint64) {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		e := c.evictableBackLocked()
+		if e == nil {
+			break
+		}
+		c.evictElemLocked(e)
+		numEvicted++
+	}
+	return numEvicted
+}
+
+// CacheStats returns a snapshot of the Store's LRU item-cache
+// hit/miss/eviction/byte counters.  Returns the zero value if the
+// Store has no cache configured.
+func (s *Store) CacheStats() CacheStats {
+	return s.itemCache.Stats()
+}
+
+// Stats returns a snapshot of the cache's hit/miss/eviction/byte counters.
+func (c *itemCache) Stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Bytes:     c.curBytes,
+	}
+}
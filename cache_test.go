@@ -0,0 +1,123 @@
+package gkvlite
+
+import (
+	"bytes"
+	"testing"
+)
+
+// newTestCollectionCached is like newTestCollection but configures
+// the Store with a tiny LRU item-cache budget, so eviction pressure
+// can be exercised deterministically within a single test.
+func newTestCollectionCached(t *testing.T, maxBytes uint64) *Collection {
+	s, err := NewStoreEx(nil, StoreCallbacks{ItemCacheBytes: maxBytes})
+	if err != nil {
+		t.Fatalf("NewStoreEx: %v", err)
+	}
+	c := s.MakePrivateCollection(bytes.Compare)
+	s.SetCollection("test", c)
+	return c
+}
+
+// TestSetItemUnflushedSurvivesCachePressure regression-tests that an
+// item which has only been Set(), never Write()'n, can't be evicted
+// by the LRU item cache: SetItem() used to admit not-yet-persisted
+// items into the cache, and the eviction path didn't check whether an
+// item had a disk Loc yet, so a tiny cache budget could silently drop
+// a pending write before it was ever flushed.
+func TestSetItemUnflushedSurvivesCachePressure(t *testing.T) {
+	c := newTestCollectionCached(t, 1)
+	want := bytes.Repeat([]byte("x"), 100)
+	for i := 0; i < 20; i++ {
+		if err := c.Set([]byte{byte(i)}, want); err != nil {
+			t.Fatalf("Set(%d): %v", i, err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		val, err := c.Get([]byte{byte(i)})
+		if err != nil {
+			t.Fatalf("Get(%d): %v", i, err)
+		}
+		if !bytes.Equal(val, want) {
+			t.Fatalf("Get(%d) = %q, want %q (unflushed item evicted before it was ever written)", i, val, want)
+		}
+	}
+}
+
+// TestWriteAdmitsIntoCache regression-tests that flushItems() still
+// admits items into the cache once they've actually been persisted,
+// so the cache isn't left permanently empty after the SetItem() fix
+// above.
+func TestWriteAdmitsIntoCache(t *testing.T) {
+	c := newTestCollectionCached(t, 1<<20)
+	if err := c.Set([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := c.Write(); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if stats := c.store.CacheStats(); stats.Bytes == 0 {
+		t.Fatalf("CacheStats().Bytes = 0 after Write(), want > 0 (flushItems should admit the persisted item)")
+	}
+}
+
+// TestGetAdmitsOnCacheMiss regression-tests that a plain read of an
+// already-persisted item admits it into the LRU item cache: a miss
+// reported by touch() used to only ever bump the hit/miss counters,
+// never adding the item to elems/curBytes, so a key this process never
+// itself flushed (or one this cache had already evicted once) stayed
+// permanently invisible to the byte budget no matter how many times
+// it was read back.
+func TestGetAdmitsOnCacheMiss(t *testing.T) {
+	c := newTestCollectionCached(t, 1<<20)
+	if err := c.Set([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := c.Write(); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	c.store.itemCache.Purge()
+	if stats := c.store.CacheStats(); stats.Bytes != 0 {
+		t.Fatalf("CacheStats().Bytes = %d after Purge(), want 0", stats.Bytes)
+	}
+
+	val, err := c.Get([]byte("k"))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !bytes.Equal(val, []byte("v")) {
+		t.Fatalf("Get = %q, want %q", val, "v")
+	}
+	if stats := c.store.CacheStats(); stats.Bytes == 0 {
+		t.Fatalf("CacheStats().Bytes = 0 after Get() re-read a purged item, want > 0 (a cache miss on read should admit the item)")
+	}
+}
+
+// TestSnapshotGetItemAdmitsOnCacheMiss regression-tests that a read
+// via Snapshot.GetItem() participates in the LRU item cache the same
+// way Collection.GetItem() does: Snapshot.GetItem() used to
+// reimplement its own node-walk instead of delegating to
+// getItemFromRoot(), so snapshot reads never touched/admitted and
+// were invisible to CacheStats().
+func TestSnapshotGetItemAdmitsOnCacheMiss(t *testing.T) {
+	c := newTestCollectionCached(t, 1<<20)
+	if err := c.Set([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := c.Write(); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	c.store.itemCache.Purge()
+
+	snap := c.Snapshot()
+	defer snap.Close()
+	val, err := snap.Get([]byte("k"))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !bytes.Equal(val, []byte("v")) {
+		t.Fatalf("Get = %q, want %q", val, "v")
+	}
+	if stats := c.store.CacheStats(); stats.Bytes == 0 {
+		t.Fatalf("CacheStats().Bytes = 0 after Snapshot.Get() re-read a purged item, want > 0")
+	}
+}
@@ -0,0 +1,111 @@
+package gkvlite
+
+// ItemCodec allows an application to control how an Item's value is
+// encoded to and decoded from the bytes written to the on-disk item
+// record, so that alternate encodings (length-prefixed framing,
+// alternate value layouts, etc) can be layered underneath the raw
+// item format without changing Collection's API.  Encode is given
+// the whole Item, but its output replaces only the stored Val; the
+// Key is always stored as-is.
+type ItemCodec interface {
+	Encode(i *Item) ([]byte, error)
+	Decode(b []byte, i *Item) error
+}
+
+// Compressor allows an application to plug in a value-payload
+// compressor (e.g. snappy, lz4, zstd) for a Collection, applied
+// after ItemCodec encoding and before the bytes are written to the
+// underlying file.
+type Compressor interface {
+	Compress(src []byte) ([]byte, error)
+	Decompress(src []byte) ([]byte, error)
+}
+
+// itemFlagCodec is stored alongside itemFlagMerge in an on-disk
+// item's shared Flag byte; set when the item's Val was written
+// through a configured ItemCodec/Compressor rather than the default
+// raw encoding.  Never set on a pending merge item (see merge.go),
+// since a merge operand chain bypasses the codec entirely.
+const itemFlagCodec = uint8(0x02)
+
+// rawItemCodec is the zero-value, backward-compatible ItemCodec
+// used when a Collection has no ItemCodec configured.
+type rawItemCodec struct{}
+
+func (rawItemCodec) Encode(i *Item) ([]byte, error) { return i.Val, nil }
+
+func (rawItemCodec) Decode(b []byte, i *Item) error {
+	i.Val = b
+	return nil
+}
+
+// applyItemCodecCallback lets a Store pick a Collection's ItemCodec by
+// name at collection-creation time, via an optional
+// StoreCallbacks.ItemCodec(name string) selector.
+func (t *Collection) applyItemCodecCallback(cb StoreCallbacks) {
+	if cb.ItemCodec != nil {
+		if c := cb.ItemCodec(t.name); c != nil {
+			t.itemCodec = c
+		}
+	}
+}
+
+// codecFor returns the ItemCodec to use for a Collection, falling
+// back to the raw, backward-compatible encoding when none was
+// configured.
+func (t *Collection) codecFor() ItemCodec {
+	if t.itemCodec != nil {
+		return t.itemCodec
+	}
+	return rawItemCodec{}
+}
+
+// encodeVal runs an Item's value through the Collection's configured
+// ItemCodec and Compressor (if any), returning the on-disk bytes and
+// the itemFlagCodec bit set iff that transform was applied.
+func (t *Collection) encodeVal(i *Item) (flag uint8, b []byte, err error) {
+	if t.itemCodec == nil && t.compressor == nil {
+		return 0, i.Val, nil
+	}
+	b, err = t.codecFor().Encode(i)
+	if err != nil {
+		return 0, nil, err
+	}
+	if t.compressor != nil {
+		b, err = t.compressor.Compress(b)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	return itemFlagCodec, b, nil
+}
+
+// decodeVal reverses encodeVal, given the item's on-disk Flag byte.
+func (t *Collection) decodeVal(flag uint8, b []byte, i *Item) error {
+	if flag&itemFlagCodec == 0 {
+		return rawItemCodec{}.Decode(b, i)
+	}
+	if t.compressor != nil {
+		var err error
+		b, err = t.compressor.Decompress(b)
+		if err != nil {
+			return err
+		}
+	}
+	return t.codecFor().Decode(b, i)
+}
+
+// decodeItemVal applies decodeVal to an Item freshly read off the
+// read path, reversing whatever encodeVal applied during flush. Items
+// without the itemFlagCodec bit pass through unchanged, and i is never
+// mutated in place, so an already-decoded cached item is safe too.
+func (t *Collection) decodeItemVal(i *Item) (*Item, error) {
+	if i == nil || i.Flag&itemFlagCodec == 0 {
+		return i, nil
+	}
+	decoded := &Item{Key: i.Key, Val: i.Val, Priority: i.Priority, Flag: i.Flag}
+	if err := t.decodeVal(i.Flag, i.Val, decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
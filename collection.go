@@ -24,6 +24,37 @@ type Collection struct {
 	root     *rootNodeLoc // Protected by rootLock.
 
 	stats FreeStats
+
+	// itemCodec and compressor are optional, and default to raw,
+	// uncompressed encoding when nil.  See SetItemCodec()/SetCompressor().
+	itemCodec  ItemCodec
+	compressor Compressor
+
+	// indexes are notified after every successful SetItem/Delete so
+	// their backing collections stay in sync.  See CreateIndex().
+	indexes []*Index
+
+	// mergeOperator, if set, lets Merge()/MergeItem() defer a
+	// read-modify-write until GetItem()/Get() (or a flush) needs to
+	// fold pending operands into a resolved value.
+	mergeOperator MergeOperator
+}
+
+// SetItemCodec configures the ItemCodec used to encode/decode this
+// Collection's item values on flush/read.  It must be set before
+// any items are flushed; changing it on a Collection that already
+// has flushed items using a different codec makes those items
+// unreadable.  Passing nil restores the default raw encoding.
+func (t *Collection) SetItemCodec(c ItemCodec) {
+	t.itemCodec = c
+}
+
+// SetCompressor configures the Compressor used to compress/
+// decompress this Collection's item values on flush/read, layered
+// on top of any configured ItemCodec.  Passing nil disables
+// compression.
+func (t *Collection) SetCompressor(c Compressor) {
+	t.compressor = c
 }
 
 type rootNodeLoc struct {
@@ -40,7 +71,9 @@ type rootNodeLoc struct {
 
 	// More nodes to maybe reclaim when our reference count goes to 0.
 	// But they might be repeated, so we scan for them during reclaimation.
-	reclaimLater [2]*node
+	// Sized dynamically rather than a small fixed array, since
+	// ApplyBatch() may mint more than a couple of fresh nodes per rootCAS.
+	reclaimLater []*node
 }
 
 func (t *Collection) Name() string {
@@ -65,9 +98,28 @@ func (t *Collection) closeCollection() { // Just "close" is a keyword.
 // to save on I/O and memory resources, especially for large values.
 // The returned Item should be treated as immutable.
 func (t *Collection) GetItem(key []byte, withValue bool) (i *Item, err error) {
+	return t.getItem(key, withValue, true)
+}
+
+// getItem is GetItem's implementation, with resolveMerges broken out
+// so that MergeItem() can fetch the raw, possibly-still-pending item
+// (Flag and all) without GetItem() folding it via resolveMerge()
+// first and losing track of whether it was a pending merge.
+func (t *Collection) getItem(key []byte, withValue bool, resolveMerges bool) (i *Item, err error) {
 	rnl := t.rootAddRef()
 	defer t.rootDecRef(rnl)
-	n := rnl.root
+	return t.getItemFromRoot(rnl.root, key, withValue, resolveMerges)
+}
+
+// getItemFromRoot is getItem's lookup loop against an explicit,
+// already-pinned root, so a caller that has already pinned a root for
+// its own mutation (SetItem/Delete/ApplyBatch, reading a key's "prev"
+// item before applying their own change) can look that key up against
+// the exact root it's about to replace, rather than racing a second,
+// independently-pinned rootAddRef against concurrent mutations that
+// could land between the two and hand back a "prev" that was never
+// actually the predecessor of the value the rootCAS replaces.
+func (t *Collection) getItemFromRoot(n *nodeLoc, key []byte, withValue bool, resolveMerges bool) (i *Item, err error) {
 	for {
 		nNode, err := n.read(t.store)
 		if err != nil || n.isEmpty() || nNode == nil {
@@ -87,11 +139,26 @@ func (t *Collection) GetItem(key []byte, withValue bool) (i *Item, err error) {
 		} else if c > 0 {
 			n = &nNode.right
 		} else {
+			var cacheMiss bool
+			if t.store != nil {
+				cacheMiss = t.store.itemCache.touch(t, nNode)
+			}
 			if withValue {
 				iItem, err = i.read(t, withValue)
 				if err != nil {
 					return nil, err
 				}
+				iItem, err = t.decodeItemVal(iItem)
+				if err != nil {
+					return nil, err
+				}
+				if cacheMiss && t.store != nil && !i.Loc().isEmpty() {
+					// Admit items read back off disk, not just ones flushItems() just wrote.
+					t.store.itemCache.admit(t, nNode, uint64(len(iItem.Key))+uint64(iItem.NumValBytes(t)))
+				}
+				if resolveMerges {
+					return t.resolveMerge(iItem)
+				}
 			}
 			return iItem, nil
 		}
@@ -127,6 +194,21 @@ func (t *Collection) SetItem(item *Item) (err error) {
 	rnl := t.rootAddRef()
 	defer t.rootDecRef(rnl)
 	root := rnl.root
+	var prev, cur *Item
+	if len(t.indexes) > 0 {
+		prev, err = t.getItemFromRoot(root, item.Key, true, true)
+		if err != nil {
+			return err
+		}
+		// item may be a pending itemFlagMerge envelope from
+		// MergeItem(); resolve it the same way prev already is so
+		// indexes extract from the actual value, not merge-operand
+		// framing.
+		cur, err = t.resolveMerge(item)
+		if err != nil {
+			return err
+		}
+	}
 	n := t.mkNode(nil, nil, nil, 1, uint64(len(item.Key))+uint64(item.NumValBytes(t)))
 	n.item.item = unsafe.Pointer(item) // Avoid garbage via separate init.
 	nloc := t.mkNodeLoc(n)
@@ -135,13 +217,15 @@ func (t *Collection) SetItem(item *Item) (err error) {
 		return err
 	}
 	rnlNew := t.mkRootNodeLoc(r)
-	rnlNew.reclaimLater[0] = n // Can't reclaim n right now because r might point to n.
+	rnlNew.reclaimLater = []*node{n} // Can't reclaim n right now because r might point to n.
 	if !t.rootCAS(rnl, rnlNew) {
 		return errors.New("concurrent mutation attempted")
 	}
 	t.rootDecRef(rnl)
 	t.freeNodeLoc(nloc)
-	return nil
+	// Not admitted into t.store.itemCache here: item isn't on disk
+	// yet, so it can't safely be evicted. flushItems() admits it once persisted.
+	return syncIndexes(t.indexes, func(idx *Index) error { return idx.onSet(prev, cur) })
 }
 
 // Replace or insert an item of a given key.
@@ -154,7 +238,7 @@ func (t *Collection) Delete(key []byte) (wasDeleted bool, err error) {
 	rnl := t.rootAddRef()
 	defer t.rootDecRef(rnl)
 	root := rnl.root
-	i, err := t.GetItem(key, false)
+	i, err := t.getItemFromRoot(root, key, len(t.indexes) > 0, true)
 	if err != nil || i == nil {
 		return false, err
 	}
@@ -175,10 +259,10 @@ func (t *Collection) Delete(key []byte) (wasDeleted bool, err error) {
 	}
 	rnlNew := t.mkRootNodeLoc(r)
 	if !left.isEmpty() { // Can't reclaim left right now due to readers.
-		rnlNew.reclaimLater[0] = left.Node()
+		rnlNew.reclaimLater = append(rnlNew.reclaimLater, left.Node())
 	}
 	if !right.isEmpty() { // Can't reclaim right right now due to readers.
-		rnlNew.reclaimLater[1] = right.Node()
+		rnlNew.reclaimLater = append(rnlNew.reclaimLater, right.Node())
 	}
 	if !t.rootCAS(rnl, rnlNew) {
 		return false, errors.New("concurrent mutation attempted")
@@ -186,7 +270,7 @@ func (t *Collection) Delete(key []byte) (wasDeleted bool, err error) {
 	t.rootDecRef(rnl)
 	t.freeNodeLoc(left)
 	t.freeNodeLoc(right)
-	return true, nil
+	return true, syncIndexes(t.indexes, func(idx *Index) error { return idx.onDelete(i) })
 }
 
 // Retrieves the item with the "smallest" key.
@@ -203,8 +287,16 @@ func (t *Collection) MaxItem(withValue bool) (*Item, error) {
 		func(n *node) (*nodeLoc, bool) { return &n.right, true })
 }
 
-// Evict some clean items found by randomly walking a tree branch.
+// EvictSomeItems evicts resident items to bound memory use.  If the
+// Store has an LRU item cache configured (see StoreCallbacks.
+// ItemCacheBytes), this is now a thin wrapper around the cache's
+// Purge(), which evicts by recency rather than at random.  Stores
+// without a configured cache fall back to the original random walk,
+// so existing callers see no behavior change.
 func (t *Collection) EvictSomeItems() (numEvicted uint64) {
+	if t.store != nil && t.store.itemCache != nil {
+		return t.store.itemCache.Purge()
+	}
 	t.store.walk(t, false, func(n *node) (*nodeLoc, bool) {
 		if !n.item.Loc().isEmpty() {
 			atomic.StorePointer(&n.item.item, unsafe.Pointer(nil))
@@ -240,23 +332,59 @@ func (t *Collection) VisitItemsDescend(target []byte, withValue bool, v ItemVisi
 // Visit items greater-than-or-equal to the target key in ascending order; with depth info.
 func (t *Collection) VisitItemsAscendEx(target []byte, withValue bool,
 	visitor ItemVisitorEx) error {
-	rnl := t.rootAddRef()
-	defer t.rootDecRef(rnl)
-	_, err := t.store.visitNodes(t, rnl.root,
-		target, withValue, visitor, 0, ascendChoice)
-	return err
+	return t.visitNodesEx(target, withValue, visitor, ascendChoice)
 }
 
 // Visit items less-than the target key in descending order; with depth info.
 func (t *Collection) VisitItemsDescendEx(target []byte, withValue bool,
 	visitor ItemVisitorEx) error {
+	return t.visitNodesEx(target, withValue, visitor, descendChoice)
+}
+
+// visitNodesEx pins the root and runs a single visitNodes() pass with
+// the given choice function, resolving any pending merges seen along
+// the way.  Factored out of VisitItemsAscendEx/DescendEx so
+// VisitItemsDescendFrom() (range.go) can reuse it with an
+// inclusive-of-target choice function.
+func (t *Collection) visitNodesEx(target []byte, withValue bool,
+	visitor ItemVisitorEx, choice func(cmp int, n *node) (bool, *nodeLoc, *nodeLoc)) error {
 	rnl := t.rootAddRef()
 	defer t.rootDecRef(rnl)
+	v, resolveErr := t.mergeResolvingVisitor(withValue, visitor)
 	_, err := t.store.visitNodes(t, rnl.root,
-		target, withValue, visitor, 0, descendChoice)
+		target, withValue, v, 0, choice)
+	if *resolveErr != nil {
+		return *resolveErr
+	}
 	return err
 }
 
+// mergeResolvingVisitor wraps visitor so every Item it sees (when
+// withValue is true) has had pending merge operands already folded,
+// instead of visitors special-casing itemFlagMerge themselves.  The
+// returned error pointer is set if resolveMerge() fails, since
+// visitNodes() can't propagate an error from inside the callback.
+func (t *Collection) mergeResolvingVisitor(withValue bool,
+	visitor ItemVisitorEx) (ItemVisitorEx, *error) {
+	var resolveErr error
+	if !withValue {
+		return visitor, &resolveErr
+	}
+	return func(i *Item, depth uint64) bool {
+		di, err := t.decodeItemVal(i)
+		if err != nil {
+			resolveErr = err
+			return false
+		}
+		ri, err := t.resolveMerge(di)
+		if err != nil {
+			resolveErr = err
+			return false
+		}
+		return visitor(ri, depth)
+	}, &resolveErr
+}
+
 func ascendChoice(cmp int, n *node) (bool, *nodeLoc, *nodeLoc) {
 	return cmp <= 0, &n.left, &n.right
 }
@@ -337,12 +465,41 @@ func (t *Collection) flushItems(nloc *nodeLoc) (err error) {
 	if err = t.flushItems(&node.left); err != nil {
 		return err
 	}
-	if err = node.item.write(t); err != nil { // Write items in key order.
+	t.collapseMergeOperands(node) // Opportunistically shrink pending operand chains.
+	if err = t.writeItemEncoded(node); err != nil { // Write items in key order.
 		return err
 	}
+	if t.store != nil {
+		if i := (*Item)(atomic.LoadPointer(&node.item.item)); i != nil {
+			t.store.itemCache.admit(t, node, uint64(len(i.Key))+uint64(i.NumValBytes(t)))
+		}
+	}
 	return t.flushItems(&node.right)
 }
 
+// writeItemEncoded writes node's item to disk, routing its Val
+// through the Collection's configured ItemCodec/Compressor (if any).
+// Pending merge items (see merge.go) are written as-is, bypassing the
+// codec.  A fresh, encoded Item is published via atomic.StorePointer
+// for the write and then swapped back, rather than mutating the
+// resident Item in place where a concurrent reader could see it
+// mid-mutation.
+func (t *Collection) writeItemEncoded(node *node) error {
+	i := (*Item)(atomic.LoadPointer(&node.item.item))
+	if i == nil || i.Flag&itemFlagMerge != 0 {
+		return node.item.write(t)
+	}
+	codecFlag, encoded, err := t.encodeVal(i)
+	if err != nil {
+		return err
+	}
+	encodedItem := &Item{Key: i.Key, Val: encoded, Priority: i.Priority, Flag: i.Flag | codecFlag}
+	atomic.StorePointer(&node.item.item, unsafe.Pointer(encodedItem))
+	err = node.item.write(t)
+	atomic.StorePointer(&node.item.item, unsafe.Pointer(i))
+	return err
+}
+
 func (t *Collection) rootCAS(prev, next *rootNodeLoc) bool {
 	t.rootLock.Lock()
 	defer t.rootLock.Unlock()
@@ -390,7 +547,7 @@ func (t *Collection) rootDecRef_unlocked(r *rootNodeLoc) {
 	if r.chainedCollection != nil && r.chainedRootNodeLoc != nil {
 		r.chainedCollection.rootDecRef_unlocked(r.chainedRootNodeLoc)
 	}
-	t.reclaimNodes_unlocked(r.root.Node(), &r.reclaimLater)
+	t.reclaimNodes_unlocked(r.root.Node(), nil)
 	for i := 0; i < len(r.reclaimLater); i++ {
 		if r.reclaimLater[i] != nil {
 			t.reclaimNodes_unlocked(r.reclaimLater[i], nil)
@@ -0,0 +1,18 @@
+package gkvlite
+
+import (
+	"bytes"
+	"testing"
+)
+
+// newTestCollection returns a fresh, in-memory-only (no backing
+// StoreFile) named Collection for use by this package's tests.
+func newTestCollection(t *testing.T) *Collection {
+	s, err := NewStore(nil)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	c := s.MakePrivateCollection(bytes.Compare)
+	s.SetCollection("test", c)
+	return c
+}
@@ -0,0 +1,424 @@
+package gkvlite
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// indexCollectionPrefix names the private, sibling Collection that
+// backs an Index, by naming convention, so a re-opened Store can
+// discover index collections by name and rebuild them lazily once the
+// application re-supplies the extract func via CreateIndex() (Go
+// closures can't themselves survive a re-open).
+const indexCollectionPrefix = "_index_"
+
+// indexBackingName computes an Index's backing Collection's name by
+// naming convention, shared by CreateIndex() (to discover/register
+// it) and rebuild() (to swap in a fresh one of the same name).
+func indexBackingName(primaryName, indexName string) string {
+	return indexCollectionPrefix + primaryName + "_" + indexName
+}
+
+// indexMetaKey is a reserved 1-byte key in an Index's backing
+// Collection used to fingerprint the primary Collection's root as
+// of the index's last rebuild.  Every real backing entry is at
+// least 3 bytes (indexEntryKey's 2-byte terminator plus a non-empty
+// primary key), so this can't collide with one.
+var indexMetaKey = []byte{0x00}
+
+// An Index is a secondary index over a Collection, keyed by bytes
+// extracted from each Item via an application-supplied extract
+// func.  It is maintained as a sibling private Collection mapping
+// indexKey||primaryKey -> primaryKey, kept in sync with the primary
+// Collection's SetItem/Delete under the same rootCAS discipline as
+// the primary tree.
+type Index struct {
+	name    string
+	primary *Collection
+	backing *Collection
+	extract func(*Item) ([]byte, error)
+}
+
+// CreateIndex creates (or reopens, by naming convention) a secondary
+// index over this Collection.  extract computes the index key for a
+// given Item; a nil return with a nil error means the item is
+// omitted from the index.
+func (t *Collection) CreateIndex(name string, extract func(*Item) ([]byte, error)) (*Index, error) {
+	if name == "" || extract == nil {
+		return nil, errors.New("Index name/extract func missing")
+	}
+	backingName := indexBackingName(t.name, name)
+	backing := t.store.GetCollection(backingName)
+	reopened := backing != nil
+	if !reopened {
+		backing = t.store.MakePrivateCollection(t.compare)
+		t.store.SetCollection(backingName, backing)
+	}
+	idx := &Index{name: name, primary: t, backing: backing, extract: extract}
+	stale, err := idx.isStale()
+	if err != nil {
+		return nil, err
+	}
+	if !reopened || stale {
+		if err := idx.rebuild(); err != nil {
+			return nil, err
+		}
+		if err := idx.markFresh(); err != nil {
+			return nil, err
+		}
+	}
+	t.indexes = append(t.indexes, idx)
+	return idx, nil
+}
+
+// isStale reports whether the backing Collection's recorded
+// fingerprint of the primary Collection's root is out of date, e.g.
+// because the primary was mutated through a handle whose onSet/
+// onDelete never touched this backing Collection.
+func (idx *Index) isStale() (bool, error) {
+	want, err := idx.primary.MarshalJSON()
+	if err != nil {
+		return false, err
+	}
+	got, err := idx.backing.Get(indexMetaKey)
+	if err != nil {
+		return false, err
+	}
+	return got == nil || !bytes.Equal(got, want), nil
+}
+
+// markFresh records the primary Collection's current root
+// fingerprint into the backing Collection, so a later CreateIndex()
+// call for this index can tell whether it's still in sync.
+func (idx *Index) markFresh() error {
+	want, err := idx.primary.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return idx.backing.Set(indexMetaKey, want)
+}
+
+// indexEntryKey builds the backing collection key for an indexed
+// item: the extracted index key, escaped so it can't contain the
+// terminator sequence, followed by a 0x00 0x00 terminator and the
+// primary key.  Escaping (rather than a length prefix) keeps plain
+// byte comparison equivalent to comparing (indexKey, primaryKey)
+// lexicographically.
+func indexEntryKey(indexKey, primaryKey []byte) []byte {
+	k := make([]byte, 0, len(indexKey)*2+len(primaryKey)+2)
+	for _, b := range indexKey {
+		if b == 0x00 {
+			k = append(k, 0x00, 0xff) // Escape embedded 0x00 as 0x00 0xff.
+		} else {
+			k = append(k, b)
+		}
+	}
+	k = append(k, 0x00, 0x00) // Terminator: sorts before any escaped continuation.
+	k = append(k, primaryKey...)
+	return k
+}
+
+func splitIndexEntryKey(k []byte) (indexKey, primaryKey []byte) {
+	escaped := false
+	for i := 0; i+1 < len(k); i++ {
+		if k[i] != 0x00 {
+			continue
+		}
+		if k[i+1] == 0x00 {
+			indexKey = k[:i]
+			if escaped {
+				indexKey = unescapeIndexKey(indexKey)
+			}
+			return indexKey, k[i+2:]
+		}
+		// k[i+1] == 0xff: an escaped 0x00 byte inside indexKey; skip past it.
+		escaped = true
+		i++
+	}
+	return nil, nil
+}
+
+func unescapeIndexKey(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if b[i] == 0x00 && i+1 < len(b) && b[i+1] == 0xff {
+			out = append(out, 0x00)
+			i++
+			continue
+		}
+		out = append(out, b[i])
+	}
+	return out
+}
+
+// IndexSyncError reports that one or more Index's onSet/onDelete
+// bookkeeping failed after the primary mutation that triggered it had
+// already committed.  Unlike "concurrent mutation attempted", this
+// means the mutation did take effect -- only the best-effort index
+// sync afterward ran into trouble.
+type IndexSyncError struct {
+	Errs []error
+}
+
+func (e *IndexSyncError) Error() string {
+	return fmt.Sprintf("gkvlite: %d index(es) failed to sync after a committed mutation, first error: %v",
+		len(e.Errs), e.Errs[0])
+}
+
+// syncIndexes runs fn for every index after the primary mutation has
+// committed, continuing past individual failures and reporting them
+// together via IndexSyncError.
+func syncIndexes(indexes []*Index, fn func(idx *Index) error) error {
+	var errs []error
+	for _, idx := range indexes {
+		if err := fn(idx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &IndexSyncError{Errs: errs}
+}
+
+// onSet keeps the index in sync when the primary Collection sets an
+// item; called by Collection.SetItem/ApplyBatch after the primary
+// mutation has committed.
+func (idx *Index) onSet(prev, cur *Item) error {
+	if prev != nil {
+		oldKey, err := idx.extract(prev)
+		if err != nil {
+			return err
+		}
+		if oldKey != nil {
+			if _, err := idx.backing.Delete(indexEntryKey(oldKey, prev.Key)); err != nil {
+				return err
+			}
+		}
+	}
+	indexKey, err := idx.extract(cur)
+	if err != nil {
+		return err
+	}
+	if indexKey != nil {
+		if err := idx.backing.Set(indexEntryKey(indexKey, cur.Key), cur.Key); err != nil {
+			return err
+		}
+	}
+	return idx.markFresh()
+}
+
+// onDelete keeps the index in sync when the primary Collection
+// deletes an item.
+func (idx *Index) onDelete(prev *Item) error {
+	if prev == nil {
+		return nil
+	}
+	indexKey, err := idx.extract(prev)
+	if err != nil {
+		return err
+	}
+	if indexKey != nil {
+		if _, err := idx.backing.Delete(indexEntryKey(indexKey, prev.Key)); err != nil {
+			return err
+		}
+	}
+	return idx.markFresh()
+}
+
+// rebuild scans the primary Collection and repopulates the backing
+// index collection from scratch.  CreateIndex() calls this when an
+// index is first created, and again later if isStale() finds the
+// backing Collection's root fingerprint out of date.  It swaps in a
+// brand-new, empty private Collection rather than Set()-ing over the
+// old one, since a stale backing Collection may hold entries under
+// index keys extract() no longer produces.
+func (idx *Index) rebuild() error {
+	backing := idx.primary.store.MakePrivateCollection(idx.primary.compare)
+	idx.primary.store.SetCollection(indexBackingName(idx.primary.name, idx.name), backing)
+	idx.backing = backing
+	return idx.primary.VisitItemsAscend(nil, true, func(i *Item) bool {
+		indexKey, err := idx.extract(i)
+		if err != nil || indexKey == nil {
+			return err == nil
+		}
+		if err := backing.Set(indexEntryKey(indexKey, i.Key), i.Key); err != nil {
+			return false
+		}
+		return true
+	})
+}
+
+// Find returns every Item in the primary Collection whose extracted
+// index key equals indexKey.
+func (idx *Index) Find(indexKey []byte) ([]*Item, error) {
+	var items []*Item
+	err := idx.RangeBounds(indexKey, true, indexKey, true, func(i *Item) bool {
+		items = append(items, i)
+		return true
+	})
+	return items, err
+}
+
+// Range visits, via the primary Collection, every Item whose
+// extracted index key falls within [lo, hi], inclusive of both
+// ends, in index-key then primary-key order.  A nil lo/hi means
+// unbounded on that end.
+func (idx *Index) Range(lo, hi []byte, visitor ItemVisitor) error {
+	return idx.RangeBounds(lo, true, hi, true, visitor)
+}
+
+// RangeBounds is like Range but lets each end of [lo, hi] be
+// exclusive, so that QueryGt/QueryLt can be expressed without
+// including their boundary value.
+func (idx *Index) RangeBounds(lo []byte, inclusiveLo bool, hi []byte, inclusiveHi bool, visitor ItemVisitor) error {
+	return idx.backing.VisitItemsAscend(indexEntryKey(lo, nil), true, func(e *Item) bool {
+		indexKey, primaryKey := splitIndexEntryKey(e.Key)
+		if indexKey == nil {
+			return false
+		}
+		if lo != nil && !inclusiveLo && idx.backing.compare(indexKey, lo) == 0 {
+			return true // Skip the excluded lower boundary; keep scanning.
+		}
+		if hi != nil {
+			c := idx.backing.compare(indexKey, hi)
+			if c > 0 || (c == 0 && !inclusiveHi) {
+				return false
+			}
+		}
+		i, err := idx.primary.GetItem(primaryKey, true)
+		if err != nil || i == nil {
+			return err == nil
+		}
+		return visitor(i)
+	})
+}
+
+// queryOp is a comparison operator usable in a Query.Where() clause.
+type queryOp int
+
+const (
+	QueryEq queryOp = iota
+	QueryGt
+	QueryGe
+	QueryLt
+	QueryLe
+	QueryBetween
+)
+
+type queryClause struct {
+	index *Index
+	op    queryOp
+	lo    []byte
+	hi    []byte
+}
+
+// A Query composes one or more Index clauses into a small,
+// leveldb/bolt-adjacent query over a Collection, evaluated by
+// intersecting the primary keys matched by each clause.
+type Query struct {
+	clauses []queryClause
+	limit   int
+}
+
+// Where starts (or extends, chained via And) a Query against index,
+// filtering by op against val (or [val, hi] for QueryBetween, via
+// the two-argument form below).
+func (idx *Index) Where(op queryOp, val []byte) *Query {
+	q := &Query{}
+	return q.And(idx, op, val)
+}
+
+// Between starts a Query with a QueryBetween clause over [lo, hi].
+func (idx *Index) Between(lo, hi []byte) *Query {
+	return &Query{clauses: []queryClause{{index: idx, op: QueryBetween, lo: lo, hi: hi}}}
+}
+
+// And adds another clause to the Query, matched as a conjunction
+// (intersection) with the clauses already present.
+func (q *Query) And(idx *Index, op queryOp, val []byte) *Query {
+	q.clauses = append(q.clauses, queryClause{index: idx, op: op, lo: val, hi: val})
+	return q
+}
+
+// Limit caps the number of items Run() returns.  A limit of 0 (the
+// zero value) means unlimited.
+func (q *Query) Limit(n int) *Query {
+	q.limit = n
+	return q
+}
+
+func (c queryClause) bounds() (lo []byte, inclusiveLo bool, hi []byte, inclusiveHi bool) {
+	switch c.op {
+	case QueryEq:
+		return c.lo, true, c.lo, true
+	case QueryGt:
+		return c.lo, false, nil, true
+	case QueryGe:
+		return c.lo, true, nil, true
+	case QueryLt:
+		return nil, true, c.hi, false
+	case QueryLe:
+		return nil, true, c.hi, true
+	case QueryBetween:
+		return c.lo, true, c.hi, true
+	}
+	return nil, true, nil, true
+}
+
+// Run evaluates the Query, intersecting the results of each clause
+// by primary key, and returns the matching Items in primary-key
+// order, up to any configured Limit().
+func (q *Query) Run() ([]*Item, error) {
+	if len(q.clauses) == 0 {
+		return nil, nil
+	}
+	matched := make(map[string]bool)
+	first := true
+	for _, c := range q.clauses {
+		lo, inclusiveLo, hi, inclusiveHi := c.bounds()
+		this := make(map[string]bool)
+		err := c.index.RangeBounds(lo, inclusiveLo, hi, inclusiveHi, func(i *Item) bool {
+			this[string(i.Key)] = true
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if first {
+			matched = this
+			first = false
+			continue
+		}
+		for k := range matched {
+			if !this[k] {
+				delete(matched, k)
+			}
+		}
+	}
+
+	primary := q.clauses[0].index.primary
+	keys := make([][]byte, 0, len(matched))
+	for k := range matched {
+		keys = append(keys, []byte(k))
+	}
+	sort.Slice(keys, func(i, j int) bool { return primary.compare(keys[i], keys[j]) < 0 })
+
+	var items []*Item
+	for _, k := range keys {
+		i, err := primary.GetItem(k, true)
+		if err != nil {
+			return nil, err
+		}
+		if i == nil {
+			continue
+		}
+		items = append(items, i)
+		if q.limit > 0 && len(items) >= q.limit {
+			break
+		}
+	}
+	return items, nil
+}
@@ -0,0 +1,141 @@
+package gkvlite
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func byValExtract(i *Item) ([]byte, error) { return i.Val, nil }
+
+// TestIndexRebuildClearsStaleEntries regression-tests that rebuild()
+// starts from an empty backing Collection rather than layering fresh
+// entries over whatever a stale backing Collection already held --
+// otherwise an item indexed under an old key that extract() no
+// longer produces would keep showing up under that old key forever.
+func TestIndexRebuildClearsStaleEntries(t *testing.T) {
+	c := newTestCollection(t)
+	idx, err := c.CreateIndex("byval", byValExtract)
+	if err != nil {
+		t.Fatalf("CreateIndex: %v", err)
+	}
+	if err := c.Set([]byte("a"), []byte("new")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	// Simulate a backing Collection that fell stale while "a" was
+	// indexed under an old value, without going through onSet/onDelete.
+	if err := idx.backing.Set(indexEntryKey([]byte("old"), []byte("a")), []byte("a")); err != nil {
+		t.Fatalf("Set stale entry: %v", err)
+	}
+
+	if err := idx.rebuild(); err != nil {
+		t.Fatalf("rebuild: %v", err)
+	}
+
+	stale, err := idx.Find([]byte("old"))
+	if err != nil {
+		t.Fatalf("Find(old): %v", err)
+	}
+	if len(stale) != 0 {
+		t.Fatalf("Find(old) = %d items after rebuild, want 0 (stale entry not cleared)", len(stale))
+	}
+
+	fresh, err := idx.Find([]byte("new"))
+	if err != nil {
+		t.Fatalf("Find(new): %v", err)
+	}
+	if len(fresh) != 1 {
+		t.Fatalf("Find(new) = %d items after rebuild, want 1", len(fresh))
+	}
+}
+
+// TestQueryRunMatchesBySelectedKeys regression-tests that Run() only
+// returns items whose keys survived the clause intersection, in
+// primary-key order, without depending on a full scan of the primary
+// Collection to find them.
+func TestQueryRunMatchesBySelectedKeys(t *testing.T) {
+	c := newTestCollection(t)
+	idx, err := c.CreateIndex("byval", byValExtract)
+	if err != nil {
+		t.Fatalf("CreateIndex: %v", err)
+	}
+	for _, kv := range [][2]string{
+		{"c", "x"}, {"a", "x"}, {"b", "y"}, {"d", "x"},
+	} {
+		if err := c.Set([]byte(kv[0]), []byte(kv[1])); err != nil {
+			t.Fatalf("Set(%s): %v", kv[0], err)
+		}
+	}
+
+	items, err := idx.Where(QueryEq, []byte("x")).Run()
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	var gotKeys []string
+	for _, i := range items {
+		gotKeys = append(gotKeys, string(i.Key))
+	}
+	want := []string{"a", "c", "d"}
+	if len(gotKeys) != len(want) {
+		t.Fatalf("Run() keys = %v, want %v", gotKeys, want)
+	}
+	for i, k := range want {
+		if gotKeys[i] != k {
+			t.Fatalf("Run() keys = %v, want %v", gotKeys, want)
+		}
+	}
+	if !bytes.Equal(items[0].Val, []byte("x")) {
+		t.Fatalf("Run()[0].Val = %q, want %q", items[0].Val, "x")
+	}
+}
+
+// TestApplyBatchIndexSyncErrorDoesNotMaskCommit regression-tests that
+// an index failing to stay in sync after ApplyBatch's rootCAS already
+// committed is reported as an IndexSyncError, distinct from the
+// errors ApplyBatch returns when the batch itself didn't apply, and
+// that the primary mutation took effect regardless.
+func TestApplyBatchIndexSyncErrorDoesNotMaskCommit(t *testing.T) {
+	c := newTestCollection(t)
+	const failKey = "boom"
+	extract := func(i *Item) ([]byte, error) {
+		if string(i.Key) == failKey {
+			return nil, errors.New("extract failed")
+		}
+		return i.Val, nil
+	}
+	if _, err := c.CreateIndex("byval", extract); err != nil {
+		t.Fatalf("CreateIndex: %v", err)
+	}
+
+	b := c.NewBatch()
+	if err := b.Set([]byte("a"), []byte("x")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := b.Set([]byte(failKey), []byte("y")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	err := c.ApplyBatch(b)
+	if err == nil {
+		t.Fatalf("ApplyBatch() = nil, want an IndexSyncError")
+	}
+	if _, ok := err.(*IndexSyncError); !ok {
+		t.Fatalf("ApplyBatch() error = %T, want *IndexSyncError", err)
+	}
+
+	val, err := c.Get([]byte("a"))
+	if err != nil {
+		t.Fatalf("Get(a): %v", err)
+	}
+	if string(val) != "x" {
+		t.Fatalf("Get(a) = %q, want %q (batch must commit despite index sync failure)", val, "x")
+	}
+	val, err = c.Get([]byte(failKey))
+	if err != nil {
+		t.Fatalf("Get(%s): %v", failKey, err)
+	}
+	if string(val) != "y" {
+		t.Fatalf("Get(%s) = %q, want %q (batch must commit despite index sync failure)", failKey, val, "y")
+	}
+}
@@ -0,0 +1,225 @@
+package gkvlite
+
+import (
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"unsafe"
+)
+
+// MergeOperator implements the leveldb/rocksdb "merge" pattern: a
+// read-modify-write is recorded as an operand rather than requiring
+// an immediate Get+Set round-trip, and the operands are folded
+// together (and with the base value) lazily, on read or on flush.
+// This lets counters and set-append workloads mutate atomically
+// without materializing and re-writing the full value each time.
+type MergeOperator interface {
+	// FullMerge folds existing (which may be nil, if the key is
+	// new) with operands, in the order they were recorded, into the
+	// final value.  The bool result reports success; false causes
+	// the merge to be treated as failed.
+	FullMerge(key, existing []byte, operands [][]byte) ([]byte, bool)
+
+	// PartialMerge optionally folds two adjacent operands into one,
+	// without needing the base value, so a chain of operands can be
+	// collapsed before FullMerge is ever called.  The bool result
+	// reports whether the merge could be done; false leaves both
+	// operands as-is.
+	PartialMerge(key, left, right []byte) ([]byte, bool)
+
+	// Name identifies the operator, analogous to KeyCompare's role
+	// for comparison; it's for diagnostics, not persisted.
+	Name() string
+}
+
+// itemFlagMerge marks an on-disk item whose Val holds a pending
+// merge operand (or chain of operands) rather than a resolved
+// value; see Collection.Merge()/MergeItem() and the fold-on-read
+// logic in GetItem()/Get().
+const itemFlagMerge = uint8(0x01)
+
+// Merge records operand as a pending mutation against key, storing
+// it as a merge-operand item rather than reading the current value
+// immediately.  The merge is resolved lazily by the configured
+// MergeOperator on the next Get()/GetItem() for this key, or
+// opportunistically collapsed with adjacent operands on flush.
+func (t *Collection) Merge(key, operand []byte) error {
+	return t.MergeItem(&Item{Key: key, Val: operand, Priority: rand.Int31()})
+}
+
+// MergeItem is like Merge() but takes a pre-built Item, e.g. to
+// control its Priority.  Unlike SetItem(), a concurrent mutation of
+// key never causes this operand to be lost: MergeItem re-reads the
+// current operand chain and retries its rootCAS under the hood
+// rather than surfacing "concurrent mutation attempted".
+func (t *Collection) MergeItem(item *Item) error {
+	if t.mergeOperator == nil {
+		return errors.New("no MergeOperator configured for this Collection")
+	}
+	if item.Key == nil || len(item.Key) > 0xffff || len(item.Key) == 0 ||
+		item.Val == nil {
+		return errors.New("Item.Key/Val missing or too long")
+	}
+
+	operand := item.Val
+	for {
+		existing, err := t.getItem(item.Key, true, false)
+		if err != nil {
+			return err
+		}
+
+		var base []byte
+		var operands [][]byte
+		if existing != nil {
+			if existing.Flag&itemFlagMerge != 0 {
+				base, operands = decodeMergeState(existing.Val)
+			} else {
+				base = existing.Val
+			}
+		}
+		operands = append(operands, operand)
+
+		merged := &Item{Key: item.Key, Priority: item.Priority,
+			Val:  encodeMergeState(base, operands),
+			Flag: itemFlagMerge}
+		err = t.SetItem(merged)
+		if err == nil {
+			return nil
+		}
+		if err.Error() != "concurrent mutation attempted" {
+			return err
+		}
+		// Someone else's SetItem/MergeItem/Delete won the race; re-read
+		// the now-current chain and retry rather than dropping operand.
+	}
+}
+
+// SetMergeOperator configures the MergeOperator used by
+// Merge()/MergeItem() and by Get()/GetItem() to fold pending
+// operands into a resolved value.
+func (t *Collection) SetMergeOperator(op MergeOperator) {
+	t.mergeOperator = op
+}
+
+// resolveMerge folds any pending merge operands carried by i against
+// the item's actual pre-merge base value.  Called by GetItem()/Get(),
+// the VisitItems* family and Snapshot reads whenever a value is
+// requested; Collection.MinItem()/MaxItem() and reads with withValue
+// false do not call it and return a pending merge item as stored.
+func (t *Collection) resolveMerge(i *Item) (*Item, error) {
+	if i == nil || i.Flag&itemFlagMerge == 0 || t.mergeOperator == nil {
+		return i, nil
+	}
+	base, operands := decodeMergeState(i.Val)
+	val, ok := t.mergeOperator.FullMerge(i.Key, base, operands)
+	if !ok {
+		return nil, errors.New("MergeOperator.FullMerge failed for key: " + string(i.Key))
+	}
+	resolved := &Item{Key: i.Key, Val: val, Priority: i.Priority}
+	return resolved, nil
+}
+
+// collapseMergeOperands opportunistically calls PartialMerge to fold
+// adjacent operands of a pending merge item into fewer, larger ones,
+// called from flushItems() so long chains don't accumulate on disk.
+// A fresh Item carrying the collapsed Val is published via
+// atomic.StorePointer rather than mutating the resident Item in
+// place, which a concurrent reader could observe mid-mutation.
+func (t *Collection) collapseMergeOperands(node *node) {
+	i := (*Item)(atomic.LoadPointer(&node.item.item))
+	if i == nil || i.Flag&itemFlagMerge == 0 || t.mergeOperator == nil {
+		return
+	}
+	base, operands := decodeMergeState(i.Val)
+	if len(operands) < 2 {
+		return
+	}
+	collapsed := operands[:1]
+	for _, right := range operands[1:] {
+		left := collapsed[len(collapsed)-1]
+		if merged, ok := t.mergeOperator.PartialMerge(i.Key, left, right); ok {
+			collapsed[len(collapsed)-1] = merged
+			continue
+		}
+		collapsed = append(collapsed, right)
+	}
+	collapsedItem := &Item{Key: i.Key, Priority: i.Priority, Flag: i.Flag,
+		Val: encodeMergeState(base, collapsed)}
+	atomic.StorePointer(&node.item.item, unsafe.Pointer(collapsedItem))
+}
+
+// encodeMergeState/decodeMergeState frame a pending merge item's
+// original base value (if any) plus its list of operand byte slices
+// within a single Item.Val, so a pending chain rides in the existing
+// single-Val item format.
+func encodeMergeState(base []byte, operands [][]byte) []byte {
+	n := 1 + len(base)
+	if base != nil {
+		n += 4
+	}
+	for _, op := range operands {
+		n += 4 + len(op)
+	}
+	buf := make([]byte, 0, n)
+	if base != nil {
+		buf = append(buf, 1)
+		l := len(base)
+		buf = append(buf, byte(l>>24), byte(l>>16), byte(l>>8), byte(l))
+		buf = append(buf, base...)
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = append(buf, encodeMergeOperands(operands)...)
+	return buf
+}
+
+func decodeMergeState(b []byte) (base []byte, operands [][]byte) {
+	if len(b) < 1 {
+		return nil, nil
+	}
+	hasBase := b[0] == 1
+	b = b[1:]
+	if hasBase {
+		if len(b) < 4 {
+			return nil, nil
+		}
+		l := int(b[0])<<24 | int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+		b = b[4:]
+		if l > len(b) {
+			return nil, nil
+		}
+		base = b[:l]
+		b = b[l:]
+	}
+	return base, decodeMergeOperands(b)
+}
+
+// encodeMergeOperands/decodeMergeOperands frame a list of operand
+// byte slices as length-prefixed records.
+func encodeMergeOperands(operands [][]byte) []byte {
+	n := 0
+	for _, op := range operands {
+		n += 4 + len(op)
+	}
+	buf := make([]byte, 0, n)
+	for _, op := range operands {
+		l := len(op)
+		buf = append(buf, byte(l>>24), byte(l>>16), byte(l>>8), byte(l))
+		buf = append(buf, op...)
+	}
+	return buf
+}
+
+func decodeMergeOperands(b []byte) [][]byte {
+	var operands [][]byte
+	for len(b) >= 4 {
+		l := int(b[0])<<24 | int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+		b = b[4:]
+		if l > len(b) {
+			break
+		}
+		operands = append(operands, b[:l])
+		b = b[l:]
+	}
+	return operands
+}
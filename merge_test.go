@@ -0,0 +1,172 @@
+package gkvlite
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+type sumMergeOperator struct{}
+
+func (sumMergeOperator) FullMerge(key, existing []byte, operands [][]byte) ([]byte, bool) {
+	total := 0
+	if existing != nil {
+		n, err := strconv.Atoi(string(existing))
+		if err != nil {
+			return nil, false
+		}
+		total = n
+	}
+	for _, op := range operands {
+		n, err := strconv.Atoi(string(op))
+		if err != nil {
+			return nil, false
+		}
+		total += n
+	}
+	return []byte(strconv.Itoa(total)), true
+}
+
+func (sumMergeOperator) PartialMerge(key, left, right []byte) ([]byte, bool) {
+	return nil, false
+}
+
+func (sumMergeOperator) Name() string { return "sum" }
+
+// TestMergeItemConcurrent drives many concurrent Merge() calls against
+// the same key and checks every operand landed -- regression test for
+// MergeItem() silently dropping an operand on a concurrent-mutation
+// CAS race instead of retrying.
+func TestMergeItemConcurrent(t *testing.T) {
+	c := newTestCollection(t)
+	c.SetMergeOperator(sumMergeOperator{})
+
+	const numGoroutines = 20
+	var wg sync.WaitGroup
+	for i := 0; i < numGoroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Merge([]byte("counter"), []byte("1")); err != nil {
+				t.Errorf("Merge: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	val, err := c.Get([]byte("counter"))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	got, err := strconv.Atoi(string(val))
+	if err != nil {
+		t.Fatalf("Atoi(%q): %v", val, err)
+	}
+	if got != numGoroutines {
+		t.Fatalf("counter = %d, want %d (an operand was dropped)", got, numGoroutines)
+	}
+}
+
+// concatMergeOperator joins operands with "+", and actually collapses
+// adjacent operands in PartialMerge (unlike sumMergeOperator above),
+// so it exercises collapseMergeOperands() rewriting a pending merge
+// item's Val during flush.
+type concatMergeOperator struct{}
+
+func (concatMergeOperator) FullMerge(key, existing []byte, operands [][]byte) ([]byte, bool) {
+	val := string(existing)
+	for _, op := range operands {
+		if val != "" {
+			val += "+"
+		}
+		val += string(op)
+	}
+	return []byte(val), true
+}
+
+func (concatMergeOperator) PartialMerge(key, left, right []byte) ([]byte, bool) {
+	return append(append(append([]byte{}, left...), '+'), right...), true
+}
+
+func (concatMergeOperator) Name() string { return "concat" }
+
+// TestCollapseMergeOperandsWriteGetConcurrent regression-tests that
+// flushItems() collapsing a pending merge item's operands doesn't
+// race with a concurrent Get() resolving that same resident *Item:
+// run under -race, this used to flag collapseMergeOperands()'s old
+// mutate-the-resident-Item's-Val-in-place approach.
+func TestCollapseMergeOperandsWriteGetConcurrent(t *testing.T) {
+	c := newTestCollection(t)
+	c.SetMergeOperator(concatMergeOperator{})
+	if err := c.Merge([]byte("k"), []byte("a")); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if err := c.Merge([]byte("k"), []byte("b")); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := c.Write(); err != nil {
+				t.Errorf("Write: %v", err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		defer close(stop)
+		for i := 0; i < 1000; i++ {
+			val, err := c.Get([]byte("k"))
+			if err != nil {
+				t.Errorf("Get: %v", err)
+				return
+			}
+			if string(val) != "a+b" {
+				t.Errorf("Get() = %q, want %q", val, "a+b")
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+// TestMergeItemIndexesResolvedValue regression-tests that a key
+// maintained via Merge() indexes its actual, folded value rather than
+// the raw itemFlagMerge operand-chain bytes SetItem() sees: onSet()
+// used to extract straight from the unresolved item MergeItem() hands
+// SetItem(), so any indexed key ever touched by Merge() would index
+// garbage forever (merge items stay itemFlagMerge-tagged on disk
+// until a plain SetItem() overwrites them).
+func TestMergeItemIndexesResolvedValue(t *testing.T) {
+	c := newTestCollection(t)
+	c.SetMergeOperator(sumMergeOperator{})
+	idx, err := c.CreateIndex("byval", byValExtract)
+	if err != nil {
+		t.Fatalf("CreateIndex: %v", err)
+	}
+
+	if err := c.Merge([]byte("counter"), []byte("3")); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if err := c.Merge([]byte("counter"), []byte("4")); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+
+	found, err := idx.Find([]byte("7"))
+	if err != nil {
+		t.Fatalf("Find(7): %v", err)
+	}
+	if len(found) != 1 || string(found[0].Key) != "counter" {
+		t.Fatalf("Find(7) = %v, want [counter] (index should see the resolved value, not merge-operand framing)", found)
+	}
+}
@@ -0,0 +1,76 @@
+package gkvlite
+
+// VisitItemsRange visits items in ascending order starting at lo
+// (inclusive) and stopping at hi (exclusive, unless inclusiveHi is
+// true).  A nil lo/hi means unbounded on that end.
+func (t *Collection) VisitItemsRange(lo, hi []byte, inclusiveHi bool, withValue bool, v ItemVisitor) error {
+	return t.VisitItemsAscend(lo, withValue, func(i *Item) bool {
+		if hi != nil {
+			c := t.compare(i.Key, hi)
+			if c > 0 || (c == 0 && !inclusiveHi) {
+				return false
+			}
+		}
+		return v(i)
+	})
+}
+
+// VisitItemsPrefix visits, in ascending order, every item whose key
+// has the given prefix, stopping as soon as the prefix no longer
+// matches rather than scanning to the end of the collection.
+func (t *Collection) VisitItemsPrefix(prefix []byte, withValue bool, v ItemVisitor) error {
+	hi := prefixSuccessor(prefix)
+	return t.VisitItemsRange(prefix, hi, false, withValue, func(i *Item) bool {
+		if !hasPrefix(i.Key, prefix) {
+			return false
+		}
+		return v(i)
+	})
+}
+
+// prefixSuccessor computes the lexicographically smallest key that
+// is greater than every key with the given prefix, so that a range
+// scan can stop as soon as it's passed the prefix.  Returns nil
+// (meaning "no upper bound") if prefix is empty or consists
+// entirely of 0xff bytes, since no successor exists in that case.
+func prefixSuccessor(prefix []byte) []byte {
+	succ := make([]byte, len(prefix))
+	copy(succ, prefix)
+	for i := len(succ) - 1; i >= 0; i-- {
+		if succ[i] < 0xff {
+			succ[i]++
+			return succ[:i+1]
+		}
+	}
+	return nil // prefix was empty or all 0xff bytes.
+}
+
+func hasPrefix(key, prefix []byte) bool {
+	if len(key) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if key[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// VisitItemsDescendFrom visits items in descending order starting
+// at target, inclusive of target when inclusive is true.  This
+// complements VisitItemsDescend(), which always starts strictly
+// less-than target.
+func (t *Collection) VisitItemsDescendFrom(target []byte, inclusive bool, withValue bool, v ItemVisitor) error {
+	choice := descendChoice
+	if inclusive {
+		choice = descendChoiceFrom
+	}
+	return t.visitNodesEx(target, withValue,
+		func(i *Item, depth uint64) bool { return v(i) }, choice)
+}
+
+// descendChoiceFrom is descendChoice's inclusive-of-target variant.
+func descendChoiceFrom(cmp int, n *node) (bool, *nodeLoc, *nodeLoc) {
+	return cmp >= 0, &n.right, &n.left
+}
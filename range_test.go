@@ -0,0 +1,140 @@
+package gkvlite
+
+import (
+	"bytes"
+	"testing"
+)
+
+func setKeys(t *testing.T, c *Collection, keys ...string) {
+	for _, k := range keys {
+		if err := c.Set([]byte(k), []byte(k)); err != nil {
+			t.Fatalf("Set(%s): %v", k, err)
+		}
+	}
+}
+
+func visitKeys(t *testing.T, run func(v ItemVisitor) error) []string {
+	var got []string
+	if err := run(func(i *Item) bool {
+		got = append(got, string(i.Key))
+		return true
+	}); err != nil {
+		t.Fatalf("visit: %v", err)
+	}
+	return got
+}
+
+// TestVisitItemsRangeBounds covers VisitItemsRange's lo (always
+// inclusive), nil-lo/nil-hi (open-ended), and hi's inclusiveHi
+// switch between exclusive (default) and inclusive.
+func TestVisitItemsRangeBounds(t *testing.T) {
+	c := newTestCollection(t)
+	setKeys(t, c, "a", "b", "c", "d", "e")
+
+	got := visitKeys(t, func(v ItemVisitor) error {
+		return c.VisitItemsRange([]byte("b"), []byte("d"), false, true, v)
+	})
+	if want := []string{"b", "c"}; !stringSlicesEqual(got, want) {
+		t.Fatalf("[b,d) = %v, want %v", got, want)
+	}
+
+	got = visitKeys(t, func(v ItemVisitor) error {
+		return c.VisitItemsRange([]byte("b"), []byte("d"), true, true, v)
+	})
+	if want := []string{"b", "c", "d"}; !stringSlicesEqual(got, want) {
+		t.Fatalf("[b,d] = %v, want %v", got, want)
+	}
+
+	got = visitKeys(t, func(v ItemVisitor) error {
+		return c.VisitItemsRange(nil, []byte("c"), false, true, v)
+	})
+	if want := []string{"a", "b"}; !stringSlicesEqual(got, want) {
+		t.Fatalf("[nil,c) = %v, want %v", got, want)
+	}
+
+	got = visitKeys(t, func(v ItemVisitor) error {
+		return c.VisitItemsRange([]byte("d"), nil, false, true, v)
+	})
+	if want := []string{"d", "e"}; !stringSlicesEqual(got, want) {
+		t.Fatalf("[d,nil) = %v, want %v", got, want)
+	}
+}
+
+// TestVisitItemsPrefixStopsAtBoundary covers the matching prefix
+// group, that VisitItemsPrefix stops as soon as the prefix no longer
+// matches instead of scanning to the end, and the all-0xff prefix
+// case where prefixSuccessor has no upper bound to stop at.
+func TestVisitItemsPrefixStopsAtBoundary(t *testing.T) {
+	c := newTestCollection(t)
+	setKeys(t, c, "ap", "apple", "apricot", "banana", "cherry")
+
+	got := visitKeys(t, func(v ItemVisitor) error {
+		return c.VisitItemsPrefix([]byte("ap"), true, v)
+	})
+	if want := []string{"ap", "apple", "apricot"}; !stringSlicesEqual(got, want) {
+		t.Fatalf("prefix ap = %v, want %v", got, want)
+	}
+
+	c2 := newTestCollection(t)
+	setKeys(t, c2, string([]byte{0xff}), string([]byte{0xff, 0x00}), string([]byte{0xff, 0xff}))
+	got = visitKeys(t, func(v ItemVisitor) error {
+		return c2.VisitItemsPrefix([]byte{0xff}, true, v)
+	})
+	want := []string{string([]byte{0xff}), string([]byte{0xff, 0x00}), string([]byte{0xff, 0xff})}
+	if !stringSlicesEqual(got, want) {
+		t.Fatalf("prefix 0xff = %v, want %v", got, want)
+	}
+}
+
+// TestPrefixSuccessor directly exercises prefixSuccessor's byte math,
+// including the no-successor cases (empty prefix, all-0xff prefix)
+// that VisitItemsPrefix relies on to mean "no upper bound."
+func TestPrefixSuccessor(t *testing.T) {
+	tests := []struct {
+		prefix []byte
+		want   []byte
+	}{
+		{[]byte("ap"), []byte("aq")},
+		{[]byte("a"), []byte("b")},
+		{[]byte{0x01, 0xff}, []byte{0x02}},
+		{nil, nil},
+		{[]byte{}, nil},
+		{[]byte{0xff}, nil},
+		{[]byte{0xff, 0xff}, nil},
+	}
+	for _, tc := range tests {
+		got := prefixSuccessor(tc.prefix)
+		if !bytes.Equal(got, tc.want) {
+			t.Fatalf("prefixSuccessor(%v) = %v, want %v", tc.prefix, got, tc.want)
+		}
+	}
+}
+
+// TestVisitItemsDescendFromInclusive covers VisitItemsDescendFrom's
+// inclusive-of-target switch, contrasted against VisitItemsDescend's
+// always-exclusive-of-target behavior.
+func TestVisitItemsDescendFromInclusive(t *testing.T) {
+	c := newTestCollection(t)
+	setKeys(t, c, "a", "b", "c", "d")
+
+	got := visitKeys(t, func(v ItemVisitor) error {
+		return c.VisitItemsDescendFrom([]byte("c"), true, true, v)
+	})
+	if want := []string{"c", "b", "a"}; !stringSlicesEqual(got, want) {
+		t.Fatalf("descendFrom(c, inclusive) = %v, want %v", got, want)
+	}
+
+	got = visitKeys(t, func(v ItemVisitor) error {
+		return c.VisitItemsDescendFrom([]byte("c"), false, true, v)
+	})
+	if want := []string{"b", "a"}; !stringSlicesEqual(got, want) {
+		t.Fatalf("descendFrom(c, exclusive) = %v, want %v", got, want)
+	}
+
+	got = visitKeys(t, func(v ItemVisitor) error {
+		return c.VisitItemsDescend([]byte("c"), true, v)
+	})
+	if want := []string{"b", "a"}; !stringSlicesEqual(got, want) {
+		t.Fatalf("VisitItemsDescend(c) = %v, want %v", got, want)
+	}
+}
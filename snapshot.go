@@ -0,0 +1,313 @@
+package gkvlite
+
+import (
+	"sync"
+)
+
+// A Snapshot is a read-only, point-in-time view of a Collection,
+// pinning its root as of Snapshot() via the same rootAddRef()/
+// chainedRootNodeLoc machinery used for readers-during-mutation.
+// Callers must call Close() to release the pinned root, or its nodes
+// can never be reclaimed.
+type Snapshot struct {
+	coll *Collection
+	rnl  *rootNodeLoc
+
+	closeOnce sync.Once
+}
+
+// Snapshot pins the Collection's current root and returns a
+// read-only handle to it.  The returned Snapshot must be Close()'d.
+func (t *Collection) Snapshot() *Snapshot {
+	return &Snapshot{coll: t, rnl: t.rootAddRef()}
+}
+
+// Close releases the root pinned by the Snapshot.  It is safe to
+// call Close() more than once.
+func (s *Snapshot) Close() error {
+	s.closeOnce.Do(func() {
+		s.coll.rootDecRef(s.rnl)
+	})
+	return nil
+}
+
+// GetItem retrieves an item by its key from the pinned snapshot, via
+// the same getItemFromRoot() Collection.GetItem() uses, so it also
+// participates in the LRU item cache.
+func (s *Snapshot) GetItem(key []byte, withValue bool) (*Item, error) {
+	return s.coll.getItemFromRoot(s.rnl.root, key, withValue, true)
+}
+
+// Get retrieves a value by its key from the pinned snapshot.
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	i, err := s.GetItem(key, true)
+	if err != nil {
+		return nil, err
+	}
+	if i != nil {
+		return i.Val, nil
+	}
+	return nil, nil
+}
+
+// MinItem retrieves the item with the "smallest" key in the snapshot.
+func (s *Snapshot) MinItem(withValue bool) (*Item, error) {
+	return s.extreme(withValue, func(n *node) *nodeLoc { return &n.left })
+}
+
+// MaxItem retrieves the item with the "largest" key in the snapshot.
+func (s *Snapshot) MaxItem(withValue bool) (*Item, error) {
+	return s.extreme(withValue, func(n *node) *nodeLoc { return &n.right })
+}
+
+// extreme, like the visitor/iterator reads below it, walks nodes
+// directly rather than through getItemFromRoot(), so it doesn't touch
+// the LRU item cache -- the same gap Collection's own VisitItems*
+// family has.
+func (s *Snapshot) extreme(withValue bool, next func(n *node) *nodeLoc) (*Item, error) {
+	n := s.rnl.root
+	var nNode *node
+	for {
+		nn, err := n.read(s.coll.store)
+		if err != nil {
+			return nil, err
+		}
+		if n.isEmpty() || nn == nil {
+			break
+		}
+		nNode = nn
+		nxt := next(nNode)
+		if nxt.isEmpty() {
+			break
+		}
+		n = nxt
+	}
+	if nNode == nil {
+		return nil, nil
+	}
+	i, err := nNode.item.read(s.coll, withValue)
+	if err != nil || !withValue {
+		return i, err
+	}
+	i, err = s.coll.decodeItemVal(i)
+	if err != nil {
+		return nil, err
+	}
+	return s.coll.resolveMerge(i)
+}
+
+// VisitItemsAscend visits items greater-than-or-equal to target, in
+// ascending order, against the pinned snapshot.
+func (s *Snapshot) VisitItemsAscend(target []byte, withValue bool, v ItemVisitor) error {
+	visitor, resolveErr := s.mergeResolvingVisitor(withValue, v)
+	_, err := s.coll.store.visitNodes(s.coll, s.rnl.root, target, withValue,
+		visitor, 0, ascendChoice)
+	if *resolveErr != nil {
+		return *resolveErr
+	}
+	return err
+}
+
+// VisitItemsDescend visits items less-than target, in descending
+// order, against the pinned snapshot.
+func (s *Snapshot) VisitItemsDescend(target []byte, withValue bool, v ItemVisitor) error {
+	visitor, resolveErr := s.mergeResolvingVisitor(withValue, v)
+	_, err := s.coll.store.visitNodes(s.coll, s.rnl.root, target, withValue,
+		visitor, 0, descendChoice)
+	if *resolveErr != nil {
+		return *resolveErr
+	}
+	return err
+}
+
+// mergeResolvingVisitor mirrors Collection.mergeResolvingVisitor,
+// folding pending merge operands before v sees an Item.
+func (s *Snapshot) mergeResolvingVisitor(withValue bool,
+	v ItemVisitor) (ItemVisitorEx, *error) {
+	var resolveErr error
+	if !withValue {
+		return func(i *Item, depth uint64) bool { return v(i) }, &resolveErr
+	}
+	return func(i *Item, depth uint64) bool {
+		di, err := s.coll.decodeItemVal(i)
+		if err != nil {
+			resolveErr = err
+			return false
+		}
+		ri, err := s.coll.resolveMerge(di)
+		if err != nil {
+			resolveErr = err
+			return false
+		}
+		return v(ri)
+	}, &resolveErr
+}
+
+// GetTotals returns the total number of items and total key bytes
+// plus value bytes as of the pinned snapshot.
+func (s *Snapshot) GetTotals() (numItems uint64, numBytes uint64, err error) {
+	n := s.rnl.root
+	nNode, err := n.read(s.coll.store)
+	if err != nil || n.isEmpty() || nNode == nil {
+		return 0, 0, err
+	}
+	return nNode.numNodes, nNode.numBytes, nil
+}
+
+// A StoreSnapshot is a read-only, point-in-time view across every
+// Collection in a Store, obtained by taking a Snapshot() of each.
+type StoreSnapshot struct {
+	store *Store
+	colls map[string]*Snapshot
+}
+
+// Snapshot pins the current root of every Collection in the Store.
+func (s *Store) Snapshot() *StoreSnapshot {
+	ss := &StoreSnapshot{store: s, colls: make(map[string]*Snapshot)}
+	for _, name := range s.GetCollectionNames() {
+		if coll := s.GetCollection(name); coll != nil {
+			ss.colls[name] = coll.Snapshot()
+		}
+	}
+	return ss
+}
+
+// GetCollection returns the pinned Snapshot for the named
+// Collection, or nil if no such collection existed when the
+// StoreSnapshot was taken.
+func (ss *StoreSnapshot) GetCollection(name string) *Snapshot {
+	return ss.colls[name]
+}
+
+// Close releases every Collection root pinned by the StoreSnapshot.
+func (ss *StoreSnapshot) Close() error {
+	for _, s := range ss.colls {
+		if err := s.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SnapshotIterator provides a pausable, seekable traversal over a
+// Snapshot, as an alternative to the visitor-callback style of
+// VisitItemsAscend/Descend for callers who want to range over a
+// stable view of the data with an imperative Next()/Prev() loop.
+type SnapshotIterator struct {
+	snap    *Snapshot
+	current *Item
+	descend bool
+	done    bool
+}
+
+// SeekGE positions the iterator at the first item greater-than-or-
+// equal to target, to be visited in ascending order by Next().
+func (s *Snapshot) SeekGE(target []byte) (*SnapshotIterator, error) {
+	it := &SnapshotIterator{snap: s}
+	i, err := it.seek(target, ascendChoice)
+	if err != nil {
+		return nil, err
+	}
+	it.current = i
+	it.done = i == nil
+	return it, nil
+}
+
+// SeekLT positions the iterator at the last item strictly less-than
+// target, to be visited in descending order by Prev().  Next() still
+// moves in ascending order from the current item.
+func (s *Snapshot) SeekLT(target []byte) (*SnapshotIterator, error) {
+	it := &SnapshotIterator{snap: s, descend: true}
+	i, err := it.seek(target, descendChoice)
+	if err != nil {
+		return nil, err
+	}
+	it.current = i
+	it.done = i == nil
+	return it, nil
+}
+
+func (it *SnapshotIterator) seek(target []byte,
+	choice func(cmp int, n *node) (bool, *nodeLoc, *nodeLoc)) (*Item, error) {
+	var found *Item
+	var resolveErr error
+	_, err := it.snap.coll.store.visitNodes(it.snap.coll, it.snap.rnl.root,
+		target, true, func(i *Item, depth uint64) bool {
+			di, err := it.snap.coll.decodeItemVal(i)
+			if err != nil {
+				resolveErr = err
+				return false
+			}
+			found, resolveErr = it.snap.coll.resolveMerge(di)
+			return false // Stop after the first (nearest) item.
+		}, 0, choice)
+	if resolveErr != nil {
+		return nil, resolveErr
+	}
+	return found, err
+}
+
+// Valid reports whether the iterator is currently positioned at an item.
+func (it *SnapshotIterator) Valid() bool {
+	return !it.done && it.current != nil
+}
+
+// Item returns the item at the iterator's current position.
+func (it *SnapshotIterator) Item() *Item {
+	return it.current
+}
+
+// Next advances the iterator to the next item in ascending order.
+func (it *SnapshotIterator) Next() error {
+	if it.done || it.current == nil {
+		return nil
+	}
+	i, err := it.seekNext(it.current.Key, ascendChoiceStrict)
+	if err != nil {
+		return err
+	}
+	it.current = i
+	it.done = i == nil
+	return nil
+}
+
+// Prev retreats the iterator to the previous item in descending order.
+func (it *SnapshotIterator) Prev() error {
+	if it.done || it.current == nil {
+		return nil
+	}
+	i, err := it.seekNext(it.current.Key, descendChoice)
+	if err != nil {
+		return err
+	}
+	it.current = i
+	it.done = i == nil
+	return nil
+}
+
+func (it *SnapshotIterator) seekNext(key []byte,
+	choice func(cmp int, n *node) (bool, *nodeLoc, *nodeLoc)) (*Item, error) {
+	var found *Item
+	var resolveErr error
+	_, err := it.snap.coll.store.visitNodes(it.snap.coll, it.snap.rnl.root,
+		key, true, func(i *Item, depth uint64) bool {
+			di, err := it.snap.coll.decodeItemVal(i)
+			if err != nil {
+				resolveErr = err
+				return false
+			}
+			found, resolveErr = it.snap.coll.resolveMerge(di)
+			return false
+		}, 0, choice)
+	if resolveErr != nil {
+		return nil, resolveErr
+	}
+	return found, err
+}
+
+// ascendChoiceStrict skips the target itself, landing strictly after it.
+// descendChoice is already the exclusive-of-target variant needed for
+// Prev()/SeekLT(), so there's no separate "strict" descend choice.
+func ascendChoiceStrict(cmp int, n *node) (bool, *nodeLoc, *nodeLoc) {
+	return cmp < 0, &n.left, &n.right
+}
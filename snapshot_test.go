@@ -0,0 +1,62 @@
+package gkvlite
+
+import "testing"
+
+// TestSnapshotIteratorNextPrev exercises SeekGE/Next and SeekLT/Prev
+// end-to-end against a pinned Snapshot, covering descendChoice's
+// exclusive-of-target semantics used by both SeekLT and Prev.
+func TestSnapshotIteratorNextPrev(t *testing.T) {
+	c := newTestCollection(t)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		if err := c.Set([]byte(k), []byte(k)); err != nil {
+			t.Fatalf("Set(%s): %v", k, err)
+		}
+	}
+
+	snap := c.Snapshot()
+	defer snap.Close()
+
+	it, err := snap.SeekGE([]byte("b"))
+	if err != nil {
+		t.Fatalf("SeekGE: %v", err)
+	}
+	var ascended []string
+	for it.Valid() {
+		ascended = append(ascended, string(it.Item().Key))
+		if err := it.Next(); err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+	}
+	wantAscend := []string{"b", "c", "d"}
+	if !stringSlicesEqual(ascended, wantAscend) {
+		t.Fatalf("ascended = %v, want %v", ascended, wantAscend)
+	}
+
+	it, err = snap.SeekLT([]byte("c"))
+	if err != nil {
+		t.Fatalf("SeekLT: %v", err)
+	}
+	var descended []string
+	for it.Valid() {
+		descended = append(descended, string(it.Item().Key))
+		if err := it.Prev(); err != nil {
+			t.Fatalf("Prev: %v", err)
+		}
+	}
+	wantDescend := []string{"b", "a"}
+	if !stringSlicesEqual(descended, wantDescend) {
+		t.Fatalf("descended = %v, want %v", descended, wantDescend)
+	}
+}
+
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}